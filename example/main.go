@@ -21,7 +21,6 @@ func main() {
 	if err != nil {
 		return
 	}
-	defer service.Stop()
 
 	{
 		service.AddHTTPServer(&http.Server{
@@ -2,17 +2,29 @@ package app
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
-	"os"
 	"sync/atomic"
 	"time"
 
+	"github.com/fullstorydev/grpchan/inprocgrpc"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/justinas/alice"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
+	"github.com/soheilhy/cmux"
+	"go.uber.org/multierr"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type Option interface {
@@ -36,33 +48,116 @@ type Redis interface {
 }
 
 type GRPCServer struct {
-	address string
-	server  *grpc.Server
+	address      string
+	server       *grpc.Server
+	health       *health.Server
+	serviceNames []string
+}
+
+type muxedServer struct {
+	address  string
+	listener net.Listener
+	mux      cmux.CMux
+}
+
+var errALPNPeekDone = errors.New("alpn peek done")
+
+// peekConn adapts an io.Reader to net.Conn so crypto/tls can parse a
+// ClientHello out of it. Writes fail with io.ErrClosedPipe, so nothing
+// reaches the client: tlsALPNMatcher aborts the handshake (via
+// GetConfigForClient) as soon as the ClientHello is parsed, and the only
+// write crypto/tls then attempts is the alert it sends on that abort.
+type peekConn struct {
+	io.Reader
+}
+
+func (peekConn) Write(p []byte) (int, error)      { return 0, io.ErrClosedPipe }
+func (peekConn) Close() error                     { return nil }
+func (peekConn) LocalAddr() net.Addr              { return nil }
+func (peekConn) RemoteAddr() net.Addr             { return nil }
+func (peekConn) SetDeadline(time.Time) error      { return nil }
+func (peekConn) SetReadDeadline(time.Time) error  { return nil }
+func (peekConn) SetWriteDeadline(time.Time) error { return nil }
+
+// tlsALPNMatcher returns a cmux.Matcher that identifies TLS connections
+// whose ClientHello advertises one of protos via ALPN. It only parses the
+// ClientHello and never completes the handshake, so the real TLS
+// termination (grpc.Creds / http.ServeTLS) still happens exactly once,
+// downstream of the matched listener.
+func tlsALPNMatcher(protos ...string) cmux.Matcher {
+	want := make(map[string]struct{}, len(protos))
+	for _, p := range protos {
+		want[p] = struct{}{}
+	}
+
+	return func(r io.Reader) bool {
+		var hello *tls.ClientHelloInfo
+		cfg := &tls.Config{
+			GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+				hello = h
+				return nil, errALPNPeekDone
+			},
+		}
+		_ = tls.Server(peekConn{Reader: r}, cfg).Handshake()
+		if hello == nil {
+			return false
+		}
+
+		for _, proto := range hello.SupportedProtos {
+			if _, ok := want[proto]; ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type gatewayServer struct {
+	address     string
+	mux         *runtime.ServeMux
+	registerFns []func(context.Context, *runtime.ServeMux, grpc.ClientConnInterface) error
 }
 
 type Service struct {
-	Name        string
-	ctx         context.Context
-	GRPCServers []*GRPCServer
-	HTTPServers []*http.Server
-	DB          DB
-	Redis       Redis
-	isReady     *atomic.Value
-	ErrChan     chan error
-	SubServices map[string]SubService
-	sigHandler  SignalTrap
+	Name                  string
+	ctx                   context.Context
+	GRPCServers           []*GRPCServer
+	HTTPServers           []*http.Server
+	MuxedServers          map[string]*muxedServer
+	Gateways              []*gatewayServer
+	Channel               *inprocgrpc.Channel
+	UnaryInterceptors     []grpc.UnaryServerInterceptor
+	StreamInterceptors    []grpc.StreamServerInterceptor
+	HTTPMiddlewares       []alice.Constructor
+	PrometheusRegistry    *prometheus.Registry
+	grpcMetricsRegistered bool
+	Registry              Registry
+	registeredInstances   []*ServiceInstance
+	ShutdownTimeout       time.Duration
+	ShutdownHooks         []func(context.Context) error
+	TLSConfig             *tls.Config
+	DB                    DB
+	Redis                 Redis
+	isReady               *atomic.Value
+	SubServices           map[string]SubService
+	sigHandler            SignalTrap
+	healthClientCert      *tls.Certificate
 }
 
+const defaultShutdownTimeout = 10 * time.Second
+const readinessCheckInterval = 5 * time.Second
+const grpcHealthDialTimeout = 2 * time.Second
+
 func New(ctx context.Context, name string, options ...Option) (*Service, error) {
 	isReady := &atomic.Value{}
 	isReady.Store(false)
 
 	s := &Service{
 		Name:        name,
-		ErrChan:     make(chan error),
 		ctx:         ctx,
 		isReady:     isReady,
 		SubServices: make(map[string]SubService),
+		sigHandler:  TermSignalTrap(),
 	}
 
 	for _, o := range options {
@@ -83,6 +178,12 @@ func (s *Service) SetContext(ctx context.Context) {
 }
 
 func (s *Service) AddHTTPServer(httpServer *http.Server) {
+	if len(s.HTTPMiddlewares) > 0 {
+		httpServer.Handler = alice.New(s.HTTPMiddlewares...).Then(httpServer.Handler)
+	}
+	if s.TLSConfig != nil && httpServer.TLSConfig == nil {
+		httpServer.TLSConfig = s.TLSConfig
+	}
 	s.HTTPServers = append(s.HTTPServers, httpServer)
 }
 
@@ -90,6 +191,13 @@ func (s *Service) AddGRPCService(serverName string, service interface{}, descrip
 	for _, grpcServer := range s.GRPCServers {
 		if grpcServer.address == serverName {
 			grpcServer.server.RegisterService(description, service)
+			if grpcServer.health != nil {
+				grpcServer.health.SetServingStatus(description.ServiceName, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+			}
+			grpcServer.serviceNames = append(grpcServer.serviceNames, description.ServiceName)
+			if s.Channel != nil {
+				s.Channel.RegisterService(description, service)
+			}
 			log.Debug().Msgf("GRPC service registered. service - %s, server - %s", description.ServiceName, serverName)
 			return nil
 		}
@@ -127,76 +235,230 @@ func (s *Service) IsAlive() bool {
 }
 
 func (s *Service) Start() error {
-	ctx := s.GetContext()
+	ctx, cancel := context.WithCancel(s.GetContext())
+	defer cancel()
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	// Registered here rather than at server construction time
+	// (GRPCServerOption.Apply): grpc_prometheus.Register pre-populates each
+	// method's counters from server.GetServiceInfo(), which is only
+	// complete once every AddGRPCService call has run.
+	for _, grpcServer := range s.GRPCServers {
+		grpc_prometheus.Register(grpcServer.server)
+	}
+
+	for _, gw := range s.Gateways {
+		for _, registerFn := range gw.registerFns {
+			if err := registerFn(ctx, gw.mux, s.Channel); err != nil {
+				return fmt.Errorf("grpc-gateway: failed to register handler %v", err)
+			}
+		}
+	}
+
+	for _, m := range s.MuxedServers {
+		listener, err := net.Listen("tcp", m.address)
+		if err != nil {
+			return fmt.Errorf("cmux: failed to listen on %s: %v", m.address, err)
+		}
+		m.listener = listener
+		m.mux = cmux.New(listener)
+	}
+
+	// Matchers are registered here, synchronously and in priority order,
+	// rather than inside the g.Go closures below: cmux tries matchers in
+	// registration order, and goroutine scheduling order is not guaranteed,
+	// so registering from concurrent goroutines would make matching racy.
+	// The TLS+gRPC matcher (specific: TLS with ALPN "h2") must be
+	// registered before the TLS+HTTP matcher (general: any TLS) on a muxed
+	// address carrying both, or the general matcher would steal gRPC's
+	// encrypted connections too.
+	grpcListeners := make(map[string]net.Listener, len(s.GRPCServers))
+	for _, grpcServer := range s.GRPCServers {
+		m, ok := s.MuxedServers[grpcServer.address]
+		if !ok {
+			continue
+		}
+		if s.TLSConfig != nil {
+			grpcListeners[grpcServer.address] = m.mux.Match(tlsALPNMatcher("h2"))
+		} else {
+			grpcListeners[grpcServer.address] = m.mux.Match(cmux.HTTP2HeaderField("content-type", "application/grpc"))
+		}
+	}
+
+	httpListeners := make(map[string]net.Listener, len(s.HTTPServers))
+	for _, httpServ := range s.HTTPServers {
+		m, ok := s.MuxedServers[httpServ.Addr]
+		if !ok {
+			continue
+		}
+		if s.TLSConfig != nil {
+			httpListeners[httpServ.Addr] = m.mux.Match(cmux.TLS())
+		} else {
+			httpListeners[httpServ.Addr] = m.mux.Match(cmux.HTTP1Fast())
+		}
+	}
 
 	for _, httpServ := range s.HTTPServers {
 		httpServ := httpServ
-		go func() {
+		g.Go(func() error {
 			log.Info().Msgf("started http server address", httpServ.Addr)
 			defer log.Info().Msg("stopped http server")
 
-			if err := httpServ.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-				s.ErrChan <- fmt.Errorf("http: failed to serve %v", err)
+			if listener, ok := httpListeners[httpServ.Addr]; ok {
+				var err error
+				if httpServ.TLSConfig != nil {
+					err = httpServ.ServeTLS(listener, "", "")
+				} else {
+					err = httpServ.Serve(listener)
+				}
+				if err != nil && !errors.Is(err, http.ErrServerClosed) {
+					return fmt.Errorf("http: failed to serve %v", err)
+				}
+				return nil
 			}
-		}()
+
+			var err error
+			if httpServ.TLSConfig != nil {
+				err = httpServ.ListenAndServeTLS("", "")
+			} else {
+				err = httpServ.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("http: failed to serve %v", err)
+			}
+			return nil
+		})
 	}
 
 	for _, grpcServer := range s.GRPCServers {
 		grpcServer := grpcServer
 
-		go func() {
+		g.Go(func() error {
 			log.Info().Msgf("started grpc server address", grpcServer.address)
 			defer log.Info().Msg("stopped grpc server")
 
+			if listener, ok := grpcListeners[grpcServer.address]; ok {
+				if err := grpcServer.server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+					return fmt.Errorf("grpc: failed to serve %v", err)
+				}
+				return nil
+			}
+
 			listener, err := net.Listen("tcp", grpcServer.address)
 			if err != nil {
-				s.ErrChan <- fmt.Errorf("failed to listenn %v", err)
-				return
+				return fmt.Errorf("failed to listenn %v", err)
 			}
 
 			if err = grpcServer.server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
-				s.ErrChan <- fmt.Errorf("grpc: failed to serve %v", err)
+				return fmt.Errorf("grpc: failed to serve %v", err)
 			}
-		}()
+			return nil
+		})
 	}
 
-	go s.Ready()
+	for _, m := range s.MuxedServers {
+		m := m
+		g.Go(func() error {
+			log.Info().Msgf("started muxed server address", m.address)
+			defer log.Info().Msg("stopped muxed server")
 
-	{
-		if err := s.sigHandler.Wait(ctx); err != nil && !errors.Is(err, ErrTermSig) {
+			if err := m.mux.Serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("cmux: failed to serve %v", err)
+			}
+			return nil
+		})
+	}
+
+	go s.monitorReadiness(gctx)
+	go s.registerWithDiscovery(gctx)
+
+	g.Go(func() error {
+		err := s.sigHandler.Wait(gctx)
+		cancel()
+		if err != nil && !errors.Is(err, ErrTermSig) && !errors.Is(err, context.Canceled) {
 			log.Error().Msgf("failed to caught signal", log.Err(err))
 			return err
 		}
 		log.Info().Msg("termination signal received")
-	}
+		return nil
+	})
+
+	stopDone := make(chan error, 1)
+	go func() {
+		<-gctx.Done()
+		stopDone <- s.Stop(context.Background())
+	}()
 
-	return nil
+	serveErr := g.Wait()
+
+	return multierr.Append(serveErr, <-stopDone)
 }
 
-func (s *Service) Stop() {
-	for _, service := range s.SubServices {
-		if err := service.Close(); err != nil {
-			log.Error().Msgf("failed to stop service service %s", service.Name())
-		}
+func (s *Service) Stop(ctx context.Context) error {
+	var errs error
 
-		log.Debug().Msgf("subservice stopped subservice %s", service.Name())
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, hook := range s.ShutdownHooks {
+		if err := hook(shutdownCtx); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("shutdown hook failed: %w", err))
+		}
 	}
 
+	s.deregisterInstances()
+
 	for _, grpcServer := range s.GRPCServers {
-		grpcServer.server.GracefulStop()
+		if grpcServer.health != nil {
+			grpcServer.health.Shutdown()
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.server.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			grpcServer.server.Stop()
+		}
 		log.Debug().Msg("grpc server stopped")
 	}
 
 	for _, httpServer := range s.HTTPServers {
-		if err := httpServer.Shutdown(s.ctx); err != nil {
-			log.Error().Msgf("failed to shutdown http server %s", httpServer.Addr)
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("failed to shutdown http server %s: %w", httpServer.Addr, err))
 		}
 		log.Debug().Msg("http server stopped")
 	}
 
+	for _, m := range s.MuxedServers {
+		if m.listener != nil {
+			if err := m.listener.Close(); err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("failed to close muxed listener %s: %w", m.address, err))
+			}
+		}
+		log.Debug().Msgf("muxed server stopped %s", m.address)
+	}
+
+	for _, service := range s.SubServices {
+		if err := service.Close(); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("failed to stop subservice %s: %w", service.Name(), err))
+		}
+
+		log.Debug().Msgf("subservice stopped subservice %s", service.Name())
+	}
+
 	if s.DB != nil {
 		if err := s.DB.Close(); err != nil {
-			log.Error().Msg("failed to close connection to db")
+			errs = multierr.Append(errs, fmt.Errorf("failed to close connection to db: %w", err))
 		}
 
 		log.Debug().Msg("db stopped")
@@ -204,23 +466,45 @@ func (s *Service) Stop() {
 
 	if s.Redis != nil {
 		if err := s.Redis.Close(); err != nil {
-			log.Error().Msg("failed to close connection to redis")
+			errs = multierr.Append(errs, fmt.Errorf("failed to close connection to redis: %w", err))
 		}
 
 		log.Debug().Msg("redis stopped")
 	}
 
-	os.Exit(1)
+	return errs
+}
+
+// monitorReadiness re-evaluates Ready() on a fixed interval for as long as
+// ctx is alive, so the gRPC health server's SetServingStatus calls track
+// component health over time instead of only reflecting the status at boot.
+func (s *Service) monitorReadiness(ctx context.Context) {
+	s.Ready()
+
+	ticker := time.NewTicker(readinessCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Ready()
+		}
+	}
 }
 
 func (s *Service) Ready() {
 	areSubServicesReady := true
 	for _, subService := range s.SubServices {
-		if !subService.Ready() {
+		ready := subService.Ready()
+		if !ready {
 			log.Error().Msgf("subservice not ready subservice %s", subService.Name())
 			areSubServicesReady = false
+		} else {
+			log.Info().Msgf("subservice is ready subservice %s", subService.Name())
 		}
-		log.Info().Msgf("subservice is ready subservice %s", subService.Name())
+		s.setHealthStatus(subService.Name(), ready)
 	}
 
 	isGRPCReady := true
@@ -230,6 +514,11 @@ func (s *Service) Ready() {
 			log.Error().Msg("grpc server not ready")
 		}
 	}
+	for _, grpcServer := range s.GRPCServers {
+		for _, name := range grpcServer.serviceNames {
+			s.setHealthStatus(name, isGRPCReady)
+		}
+	}
 
 	areHTTPServersReady := true
 	for _, httpServer := range s.HTTPServers {
@@ -248,7 +537,25 @@ func (s *Service) Ready() {
 		isRedisAlive = false
 	}
 
-	s.isReady.Swap(areSubServicesReady && isGRPCReady && areHTTPServersReady && isDBReady && isRedisAlive)
+	isReady := areSubServicesReady && isGRPCReady && areHTTPServersReady && isDBReady && isRedisAlive
+
+	s.setHealthStatus(s.Name, isReady)
+	s.setHealthStatus("", isReady)
+
+	s.isReady.Swap(isReady)
+}
+
+func (s *Service) setHealthStatus(name string, ready bool) {
+	status := grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	if ready {
+		status = grpc_health_v1.HealthCheckResponse_SERVING
+	}
+
+	for _, grpcServer := range s.GRPCServers {
+		if grpcServer.health != nil {
+			grpcServer.health.SetServingStatus(name, status)
+		}
+	}
 }
 func (s *Service) checkHTTPServerUp(httpServer *http.Server) bool {
 	err := errors.New("http server not ready")
@@ -268,21 +575,24 @@ func (s *Service) checkHTTPServerUp(httpServer *http.Server) bool {
 }
 
 func (s *Service) checkGRPCServerUp() bool {
-	ctx := s.GetContext()
-	var conn *grpc.ClientConn
-	defer func() {
-		if conn != nil {
-			conn.Close()
-		}
-	}()
+	creds := insecure.NewCredentials()
+	if s.TLSConfig != nil {
+		creds = credentials.NewTLS(s.healthCheckTLSConfig())
+	}
 
 	for _, server := range s.GRPCServers {
-		var err error
-		// WithBlock will block dial until the server is ready
-		if conn, err = grpc.DialContext(ctx, server.address, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock()); err != nil {
+		dialCtx, cancel := context.WithTimeout(s.GetContext(), grpcHealthDialTimeout)
+
+		// WithBlock will block dial until the server is ready, bounded by
+		// dialCtx so a stuck server can't wedge monitorReadiness's goroutine
+		// forever.
+		conn, err := grpc.DialContext(dialCtx, server.address, grpc.WithTransportCredentials(creds), grpc.WithBlock())
+		cancel()
+		if err != nil {
 			log.Debug().Msg(err.Error())
 			return false
 		}
+		conn.Close()
 
 		log.Debug().Msgf("grpc server ready %s", server.address)
 	}
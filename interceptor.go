@@ -0,0 +1,79 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type requestIDKey struct{}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func requestID(ctx context.Context) (context.Context, string) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get("x-request-id"); len(ids) > 0 && ids[0] != "" {
+			return context.WithValue(ctx, requestIDKey{}, ids[0]), ids[0]
+		}
+	}
+	id := uuid.NewString()
+	return context.WithValue(ctx, requestIDKey{}, id), id
+}
+
+func unaryRequestIDInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, _ = requestID(ctx)
+		return handler(ctx, req)
+	}
+}
+
+func streamRequestIDInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, _ := requestID(ss.Context())
+		return handler(srv, &wrappedServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func unaryLoggingInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		log.Info().
+			Str("method", info.FullMethod).
+			Str("request_id", RequestIDFromContext(ctx)).
+			Dur("duration", time.Since(start)).
+			Err(err).
+			Msg("grpc request handled")
+		return resp, err
+	}
+}
+
+func streamLoggingInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		log.Info().
+			Str("method", info.FullMethod).
+			Str("request_id", RequestIDFromContext(ss.Context())).
+			Dur("duration", time.Since(start)).
+			Err(err).
+			Msg("grpc stream handled")
+		return err
+	}
+}
+
+type wrappedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedServerStream) Context() context.Context {
+	return w.ctx
+}
@@ -1,15 +1,25 @@
 package app
 
 import (
+	"context"
 	"net/http"
 	"net/http/pprof"
 	"time"
 
+	"github.com/fullstorydev/grpchan/inprocgrpc"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/justinas/alice"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type GRPCServerOption struct {
@@ -17,10 +27,43 @@ type GRPCServerOption struct {
 }
 
 func (w GRPCServerOption) Apply(s *Service) error {
-	grpcSrv := grpc.NewServer()
+	if s.PrometheusRegistry == nil {
+		s.PrometheusRegistry = prometheus.NewRegistry()
+	}
+	if !s.grpcMetricsRegistered {
+		s.PrometheusRegistry.MustRegister(grpc_prometheus.DefaultServerMetrics)
+		s.grpcMetricsRegistered = true
+	}
+
+	unaryInterceptors := append([]grpc.UnaryServerInterceptor{
+		grpc_recovery.UnaryServerInterceptor(),
+		unaryRequestIDInterceptor(),
+		unaryLoggingInterceptor(),
+		grpc_prometheus.UnaryServerInterceptor,
+	}, s.UnaryInterceptors...)
+
+	streamInterceptors := append([]grpc.StreamServerInterceptor{
+		grpc_recovery.StreamServerInterceptor(),
+		streamRequestIDInterceptor(),
+		streamLoggingInterceptor(),
+		grpc_prometheus.StreamServerInterceptor,
+	}, s.StreamInterceptors...)
+
+	serverOpts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
+	}
+	if s.TLSConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(s.TLSConfig)))
+	}
+
+	grpcSrv := grpc.NewServer(serverOpts...)
+
+	healthSrv := health.NewServer()
+	grpc_health_v1.RegisterHealthServer(grpcSrv, healthSrv)
 
 	s.GRPCServers = append(s.GRPCServers, &GRPCServer{
-		server: grpcSrv, address: w.address,
+		server: grpcSrv, address: w.address, health: healthSrv,
 	})
 	return nil
 }
@@ -28,6 +71,32 @@ func WithGRPCServer(address string) Option {
 	return GRPCServerOption{address: address}
 }
 
+type UnaryInterceptorsOption struct {
+	interceptors []grpc.UnaryServerInterceptor
+}
+
+func (w UnaryInterceptorsOption) Apply(s *Service) error {
+	s.UnaryInterceptors = append(s.UnaryInterceptors, w.interceptors...)
+	return nil
+}
+
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return UnaryInterceptorsOption{interceptors: interceptors}
+}
+
+type StreamInterceptorsOption struct {
+	interceptors []grpc.StreamServerInterceptor
+}
+
+func (w StreamInterceptorsOption) Apply(s *Service) error {
+	s.StreamInterceptors = append(s.StreamInterceptors, w.interceptors...)
+	return nil
+}
+
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return StreamInterceptorsOption{interceptors: interceptors}
+}
+
 type TechHTTPServerOption struct {
 	address string
 }
@@ -41,7 +110,10 @@ func (w TechHTTPServerOption) Apply(s *Service) error {
 	r.Mount("/debug/pprof", pprofRoutes())
 
 	// adding gometrics
-	prometheusRegistry := prometheus.NewRegistry()
+	if s.PrometheusRegistry == nil {
+		s.PrometheusRegistry = prometheus.NewRegistry()
+	}
+	prometheusRegistry := s.PrometheusRegistry
 	prometheusRegistry.MustRegister(collectors.NewGoCollector())
 	prometheusRegistry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
 	NewTelemtryHandler(prometheusRegistry).Register(r)
@@ -51,6 +123,7 @@ func (w TechHTTPServerOption) Apply(s *Service) error {
 	s.HTTPServers = append(s.HTTPServers, &http.Server{
 		Addr:           w.address,
 		Handler:        r,
+		TLSConfig:      s.TLSConfig,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: http.DefaultMaxHeaderBytes,
@@ -82,6 +155,95 @@ func WithTechHTTPServerOption(address string) Option {
 	return TechHTTPServerOption{address: address}
 }
 
+type MuxedServerOption struct {
+	address string
+}
+
+func (w MuxedServerOption) Apply(s *Service) error {
+	if s.MuxedServers == nil {
+		s.MuxedServers = make(map[string]*muxedServer)
+	}
+	s.MuxedServers[w.address] = &muxedServer{address: w.address}
+	return nil
+}
+
+func WithMuxedServer(address string) Option {
+	return MuxedServerOption{address: address}
+}
+
+type GRPCGatewayOption struct {
+	address     string
+	registerFns []func(context.Context, *runtime.ServeMux, grpc.ClientConnInterface) error
+}
+
+func (w GRPCGatewayOption) Apply(s *Service) error {
+	if s.Channel == nil {
+		s.Channel = &inprocgrpc.Channel{}
+	}
+
+	mux := runtime.NewServeMux()
+
+	s.Gateways = append(s.Gateways, &gatewayServer{
+		address:     w.address,
+		mux:         mux,
+		registerFns: w.registerFns,
+	})
+
+	s.HTTPServers = append(s.HTTPServers, &http.Server{
+		Addr:           w.address,
+		Handler:        mux,
+		TLSConfig:      s.TLSConfig,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: http.DefaultMaxHeaderBytes,
+	})
+
+	return nil
+}
+
+func WithGRPCGateway(address string, registerFns ...func(context.Context, *runtime.ServeMux, grpc.ClientConnInterface) error) Option {
+	return GRPCGatewayOption{address: address, registerFns: registerFns}
+}
+
+type HTTPMiddlewareOption struct {
+	middlewares []alice.Constructor
+}
+
+func (w HTTPMiddlewareOption) Apply(s *Service) error {
+	s.HTTPMiddlewares = append(s.HTTPMiddlewares, w.middlewares...)
+	return nil
+}
+
+func WithHTTPMiddleware(middlewares ...alice.Constructor) Option {
+	return HTTPMiddlewareOption{middlewares: middlewares}
+}
+
+type ShutdownTimeoutOption struct {
+	timeout time.Duration
+}
+
+func (w ShutdownTimeoutOption) Apply(s *Service) error {
+	s.ShutdownTimeout = w.timeout
+	return nil
+}
+
+func WithShutdownTimeout(d time.Duration) Option {
+	return ShutdownTimeoutOption{timeout: d}
+}
+
+type ShutdownHookOption struct {
+	hook func(context.Context) error
+}
+
+func (w ShutdownHookOption) Apply(s *Service) error {
+	s.ShutdownHooks = append(s.ShutdownHooks, w.hook)
+	return nil
+}
+
+func WithShutdownHook(hook func(context.Context) error) Option {
+	return ShutdownHookOption{hook: hook}
+}
+
 type DBOption struct {
 	db DB
 }
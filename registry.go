@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+type ServiceInstance struct {
+	ID       string
+	Name     string
+	Address  string
+	Port     int
+	Metadata map[string]string
+}
+
+type Registry interface {
+	Register(instance *ServiceInstance) error
+	Deregister(instance *ServiceInstance) error
+	KeepAlive(ctx context.Context) error
+}
+
+type RegistryOption struct {
+	registry Registry
+}
+
+func (w RegistryOption) Apply(s *Service) error {
+	s.Registry = w.registry
+	return nil
+}
+
+func WithRegistry(r Registry) Option {
+	return RegistryOption{registry: r}
+}
+
+func (s *Service) registerWithDiscovery(ctx context.Context) {
+	if s.Registry == nil {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ready, _ := s.isReady.Load().(bool)
+			if !ready {
+				continue
+			}
+
+			s.registerInstances()
+
+			if err := s.Registry.KeepAlive(ctx); err != nil {
+				log.Error().Msgf("failed to keep service registration alive %v", err)
+			}
+			return
+		}
+	}
+}
+
+func (s *Service) registerInstances() {
+	for _, grpcServer := range s.GRPCServers {
+		s.registerInstance(grpcServer.address, "grpc")
+	}
+
+	for _, httpServer := range s.HTTPServers {
+		s.registerInstance(httpServer.Addr, "http")
+	}
+}
+
+func (s *Service) registerInstance(address, protocol string) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		log.Error().Msgf("failed to parse address %s for service discovery %v", address, err)
+		return
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		log.Error().Msgf("failed to parse port %s for service discovery %v", portStr, err)
+		return
+	}
+
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host, err = outboundAddress()
+		if err != nil {
+			log.Error().Msgf("failed to resolve advertise address for service discovery %v", err)
+			return
+		}
+	}
+
+	instance := &ServiceInstance{
+		ID:      uuid.NewString(),
+		Name:    fmt.Sprintf("%s-%s", s.Name, protocol),
+		Address: host,
+		Port:    port,
+		Metadata: map[string]string{
+			"protocol": protocol,
+		},
+	}
+
+	if err = s.Registry.Register(instance); err != nil {
+		log.Error().Msgf("failed to register service instance %s %v", instance.Name, err)
+		return
+	}
+
+	s.registeredInstances = append(s.registeredInstances, instance)
+	log.Debug().Msgf("service instance registered %s at %s:%d", instance.Name, instance.Address, instance.Port)
+}
+
+// outboundAddress returns the local IP that would be used to reach the
+// network, so wildcard binds (":8080", "0.0.0.0:8080") register an
+// advertisable address instead of an empty/unroutable host. No packets are
+// actually sent: dialing UDP just resolves the route.
+func outboundAddress() (string, error) {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return "", fmt.Errorf("failed to determine outbound address: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+func (s *Service) deregisterInstances() {
+	if s.Registry == nil {
+		return
+	}
+
+	for _, instance := range s.registeredInstances {
+		if err := s.Registry.Deregister(instance); err != nil {
+			log.Error().Msgf("failed to deregister service instance %s %v", instance.Name, err)
+			continue
+		}
+		log.Debug().Msgf("service instance deregistered %s", instance.Name)
+	}
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+type ConsulRegistry struct {
+	client   *consul.Client
+	ttl      time.Duration
+	checkIDs map[string]string
+}
+
+func NewConsulRegistry(client *consul.Client, ttl time.Duration) *ConsulRegistry {
+	return &ConsulRegistry{client: client, ttl: ttl, checkIDs: make(map[string]string)}
+}
+
+func (r *ConsulRegistry) Register(instance *ServiceInstance) error {
+	tags := make([]string, 0, len(instance.Metadata))
+	for k, v := range instance.Metadata {
+		tags = append(tags, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	registration := &consul.AgentServiceRegistration{
+		ID:      instance.ID,
+		Name:    instance.Name,
+		Address: instance.Address,
+		Port:    instance.Port,
+		Tags:    tags,
+		Check: &consul.AgentServiceCheck{
+			TTL:                            r.ttl.String(),
+			DeregisterCriticalServiceAfter: (3 * r.ttl).String(),
+		},
+	}
+
+	if err := r.client.Agent().ServiceRegister(registration); err != nil {
+		return fmt.Errorf("consul: failed to register service: %w", err)
+	}
+	r.checkIDs[instance.ID] = "service:" + instance.ID
+
+	return nil
+}
+
+func (r *ConsulRegistry) Deregister(instance *ServiceInstance) error {
+	if err := r.client.Agent().ServiceDeregister(instance.ID); err != nil {
+		return fmt.Errorf("consul: failed to deregister service: %w", err)
+	}
+	return nil
+}
+
+func (r *ConsulRegistry) KeepAlive(ctx context.Context) error {
+	ticker := time.NewTicker(r.ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			for _, checkID := range r.checkIDs {
+				if err := r.client.Agent().UpdateTTL(checkID, "", consul.HealthPassing); err != nil {
+					return fmt.Errorf("consul: failed to update TTL check: %w", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+type EtcdRegistry struct {
+	client *clientv3.Client
+	ttl    int64
+	leases map[string]clientv3.LeaseID
+}
+
+func NewEtcdRegistry(client *clientv3.Client, ttlSeconds int64) *EtcdRegistry {
+	return &EtcdRegistry{client: client, ttl: ttlSeconds, leases: make(map[string]clientv3.LeaseID)}
+}
+
+func (r *EtcdRegistry) Register(instance *ServiceInstance) error {
+	lease, err := r.client.Grant(context.Background(), r.ttl)
+	if err != nil {
+		return fmt.Errorf("etcd: failed to grant lease: %w", err)
+	}
+
+	value, err := json.Marshal(instance)
+	if err != nil {
+		return fmt.Errorf("etcd: failed to marshal instance: %w", err)
+	}
+
+	if _, err = r.client.Put(context.Background(), etcdInstanceKey(instance), string(value), clientv3.WithLease(lease.ID)); err != nil {
+		return fmt.Errorf("etcd: failed to put instance: %w", err)
+	}
+
+	r.leases[instance.ID] = lease.ID
+
+	return nil
+}
+
+func (r *EtcdRegistry) Deregister(instance *ServiceInstance) error {
+	if _, err := r.client.Delete(context.Background(), etcdInstanceKey(instance)); err != nil {
+		return fmt.Errorf("etcd: failed to delete instance: %w", err)
+	}
+	return nil
+}
+
+func (r *EtcdRegistry) KeepAlive(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+
+	for _, leaseID := range r.leases {
+		leaseID := leaseID
+		g.Go(func() error {
+			keepAlive, err := r.client.KeepAlive(gctx, leaseID)
+			if err != nil {
+				return fmt.Errorf("etcd: failed to start keepalive: %w", err)
+			}
+
+			for {
+				select {
+				case <-gctx.Done():
+					return nil
+				case _, ok := <-keepAlive:
+					if !ok {
+						return fmt.Errorf("etcd: keepalive channel closed")
+					}
+				}
+			}
+		})
+	}
+
+	return g.Wait()
+}
+
+func etcdInstanceKey(instance *ServiceInstance) string {
+	return fmt.Sprintf("/services/%s/%s", instance.Name, instance.ID)
+}